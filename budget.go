@@ -0,0 +1,264 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// BudgetLine is one `Account Amount` row of a `budget` directive, e.g.
+// `Expenses:Food    $500`.
+type BudgetLine struct {
+	Account string
+	Amount  *Amount
+}
+
+// Budget is a `budget <period-expression>` directive: a set of per-account
+// budgeted amounts recurring on Period.
+type Budget struct {
+	Period Period
+	Lines  []BudgetLine
+}
+
+// BudgetAccountReport is one row of a -mode=budget report: actual vs
+// budgeted for a single account over a single period.
+type BudgetAccountReport struct {
+	Account  string
+	Budgeted decimal.Decimal
+	Actual   decimal.Decimal
+}
+
+func (r BudgetAccountReport) Variance() decimal.Decimal { return r.Actual.Sub(r.Budgeted) }
+
+// PercentUsed returns Actual as a percentage of Budgeted, or 0 if nothing
+// was budgeted for the account.
+func (r BudgetAccountReport) PercentUsed() float64 {
+	if r.Budgeted.IsZero() {
+		return 0
+	}
+
+	f, _ := r.Actual.Div(r.Budgeted).Mul(decimal.NewFromInt(100)).Float64()
+
+	return f
+}
+
+// BudgetPeriodReport groups BudgetAccountReports over a single [Start, End)
+// window.
+type BudgetPeriodReport struct {
+	Start    time.Time
+	End      time.Time
+	Accounts []BudgetAccountReport
+}
+
+// EvaluateBudget walks every declared budget and produces actual-vs-budget
+// reports. If perPeriod is false, a single report covering the whole range
+// (from the earliest budget/transaction date up to until) is returned per
+// budget, with the budgeted amount scaled by the number of periods that
+// range covers; otherwise one report per occurrence of each budget's period
+// is returned. Budgets are windowed independently (each may declare its own
+// interval), and reports sharing an identical [Start, End) window are
+// merged into one so e.g. a monthly "Expenses" budget and a monthly
+// "Income" budget still print as a single block per month.
+//
+// Roll-up: a budget declared on "Expenses" covers postings to any
+// "Expenses:*" account unless a more specific line in the same budget
+// directive exists for that account or one of its ancestors closer to it.
+// Lines of other budget directives are not consulted, so a more specific
+// line declared in a separate budget does not take postings away from this
+// one, and both rows count them.
+func EvaluateBudget(budgets []*Budget, transactions Transactions, until time.Time, perPeriod bool) []BudgetPeriodReport {
+	var reports []BudgetPeriodReport
+
+	for _, b := range budgets {
+		for _, w := range budgetWindows(b.Period, until, transactions, perPeriod) {
+			reports = append(reports, evaluateBudgetWindow(b.Lines, transactions, w.start, w.end, w.periods))
+		}
+	}
+
+	return mergeBudgetPeriodReports(reports)
+}
+
+type budgetWindow struct {
+	start, end time.Time
+	// periods is how many occurrences of the budget's period the window
+	// covers, so that a totals window spanning several periods scales the
+	// budgeted amount accordingly (a 2-month totals window against a
+	// monthly budget budgets for 2 months, not 1).
+	periods int
+}
+
+func budgetWindows(period Period, until time.Time, transactions Transactions, perPeriod bool) []budgetWindow {
+	start := period.Start
+	if start.IsZero() {
+		start = earliestDate(transactions)
+	}
+
+	end := until
+	if period.End != nil && period.End.Before(end) {
+		end = *period.End
+	}
+
+	if !perPeriod || period.Interval == "" {
+		return []budgetWindow{{start: start, end: end, periods: countPeriods(period, start, end)}}
+	}
+
+	p := period
+	p.Start = start
+
+	occurrences := p.Occurrences(end)
+
+	windows := make([]budgetWindow, 0, len(occurrences))
+	for i, d := range occurrences {
+		windowEnd := end
+		if i+1 < len(occurrences) {
+			windowEnd = occurrences[i+1]
+		}
+
+		windows = append(windows, budgetWindow{start: d, end: windowEnd, periods: 1})
+	}
+
+	return windows
+}
+
+// countPeriods returns how many times period recurs within [start, end),
+// counting a partial trailing period (one that starts before end but whose
+// next occurrence would fall on or after it) as a whole period, and
+// defaulting to 1 for a budget with no declared interval.
+func countPeriods(period Period, start, end time.Time) int {
+	if period.Interval == "" {
+		return 1
+	}
+
+	p := period
+	p.Start = start
+
+	n := 0
+	for _, d := range p.Occurrences(end) {
+		if d.Before(end) {
+			n++
+		}
+	}
+
+	if n == 0 {
+		n = 1
+	}
+
+	return n
+}
+
+func evaluateBudgetWindow(lines []BudgetLine, transactions Transactions, start, end time.Time, periods int) BudgetPeriodReport {
+	actual := make(map[string]decimal.Decimal)
+
+	for _, tx := range transactions {
+		if tx.Date.Before(start) || !tx.Date.Before(end) {
+			continue
+		}
+
+		for _, p := range tx.Postings {
+			if p.Amount == nil {
+				continue
+			}
+
+			line, ok := longestBudgetMatch(p.Account, lines)
+			if !ok {
+				continue
+			}
+
+			actual[line.Account] = actual[line.Account].Add(p.Amount.Quantity)
+		}
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+
+	for _, l := range lines {
+		if seen[l.Account] {
+			continue
+		}
+		seen[l.Account] = true
+		names = append(names, l.Account)
+	}
+	sort.Strings(names)
+
+	byAccount := make(map[string]BudgetLine, len(lines))
+	for _, l := range lines {
+		byAccount[l.Account] = l
+	}
+
+	multiplier := decimal.NewFromInt(int64(periods))
+
+	accounts := make([]BudgetAccountReport, 0, len(names))
+	for _, name := range names {
+		var budgeted decimal.Decimal
+		if a := byAccount[name].Amount; a != nil {
+			budgeted = a.Quantity.Mul(multiplier)
+		}
+
+		accounts = append(accounts, BudgetAccountReport{
+			Account:  name,
+			Budgeted: budgeted,
+			Actual:   actual[name],
+		})
+	}
+
+	return BudgetPeriodReport{Start: start, End: end, Accounts: accounts}
+}
+
+// mergeBudgetPeriodReports combines reports that cover the exact same
+// [Start, End) window (as produced by separate budgets' own windowing)
+// into one, concatenating and re-sorting their account rows, and orders
+// the result by Start.
+func mergeBudgetPeriodReports(reports []BudgetPeriodReport) []BudgetPeriodReport {
+	type key struct{ start, end time.Time }
+
+	merged := make(map[key]*BudgetPeriodReport)
+	var order []key
+
+	for _, r := range reports {
+		k := key{r.Start, r.End}
+
+		if m, ok := merged[k]; ok {
+			m.Accounts = append(m.Accounts, r.Accounts...)
+			continue
+		}
+
+		rCopy := r
+		merged[k] = &rCopy
+		order = append(order, k)
+	}
+
+	out := make([]BudgetPeriodReport, 0, len(order))
+	for _, k := range order {
+		r := *merged[k]
+		sort.Slice(r.Accounts, func(i, j int) bool { return r.Accounts[i].Account < r.Accounts[j].Account })
+		out = append(out, r)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+
+	return out
+}
+
+// longestBudgetMatch finds the budget line governing account: the line
+// whose Account is account itself or the longest ancestor of it.
+func longestBudgetMatch(account string, lines []BudgetLine) (BudgetLine, bool) {
+	var best BudgetLine
+	bestLen := -1
+	found := false
+
+	for _, l := range lines {
+		if account != l.Account && !strings.HasPrefix(account, l.Account+":") {
+			continue
+		}
+
+		if len(l.Account) > bestLen {
+			best = l
+			bestLen = len(l.Account)
+			found = true
+		}
+	}
+
+	return best, found
+}
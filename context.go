@@ -0,0 +1,103 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Alias rewrites an account name at parse time, either by an exact match
+// (`alias SRC = DST`) or a regexp substitution (`alias /regex/ = replacement`).
+type Alias struct {
+	Regexp *regexp.Regexp
+	From   string
+	To     string
+}
+
+// Context carries the state that accumulates while parsing a journal and
+// any files it includes: the default commodity set by a `D` directive, the
+// alias table, the set of declared accounts (for `-strict` mode), and the
+// set of already-included files (to guard against include cycles). It's
+// threaded through parseFile and exposed on the return value so callers
+// (and tests) can inspect parse-time state without re-parsing or shelling
+// out to the binary.
+type Context struct {
+	File             string
+	DefaultCommodity string
+	Strict           bool
+	Aliases          []Alias
+	Accounts         map[string]bool
+	Included         map[string]bool
+	LotGainAccounts  map[string]string
+}
+
+// NewContext creates a Context for parsing the journal at file.
+func NewContext(file string) *Context {
+	return &Context{
+		File:            file,
+		Accounts:        make(map[string]bool),
+		Included:        map[string]bool{file: true},
+		LotGainAccounts: make(map[string]string),
+	}
+}
+
+// ApplyAlias rewrites account according to the alias table, in declaration
+// order, returning it unchanged if nothing matches.
+func (c *Context) ApplyAlias(account string) string {
+	for _, a := range c.Aliases {
+		if a.Regexp != nil {
+			if a.Regexp.MatchString(account) {
+				return a.Regexp.ReplaceAllString(account, a.To)
+			}
+
+			continue
+		}
+
+		if account == a.From {
+			return a.To
+		}
+	}
+
+	return account
+}
+
+// LotGainAccount returns the account that realised gain/loss postings for
+// account should be posted to, as declared by the `lotgain` directive
+// matching account or the longest of its ancestors, or
+// "Income:CapitalGains:Realised" if nothing matches.
+func (c *Context) LotGainAccount(account string) string {
+	best := ""
+	bestLen := -1
+
+	for from, to := range c.LotGainAccounts {
+		if account != from && !strings.HasPrefix(account, from+":") {
+			continue
+		}
+
+		if len(from) > bestLen {
+			best = to
+			bestLen = len(from)
+		}
+	}
+
+	if best == "" {
+		return "Income:CapitalGains:Realised"
+	}
+
+	return best
+}
+
+// CheckAccount enforces -strict mode: once at least one `account` directive
+// has been seen, every posting account must have been declared.
+func (c *Context) CheckAccount(account string) error {
+	if !c.Strict || len(c.Accounts) == 0 {
+		return nil
+	}
+
+	if !c.Accounts[account] {
+		return errors.Errorf("account %q was not declared with an 'account' directive", account)
+	}
+
+	return nil
+}
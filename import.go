@@ -0,0 +1,334 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aclindsa/ofxgo"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// ImportConfig maps a statement's account id (an OFX <ACCTID>, or the
+// -account id given to a CSV import) to the journal account it should post
+// against, e.g. {"accounts": {"1234567890": "Assets:Checking"}}.
+type ImportConfig struct {
+	Accounts map[string]string `json:"accounts"`
+}
+
+// LoadImportConfig reads an ImportConfig from a JSON file.
+func LoadImportConfig(path string) (*ImportConfig, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "LoadImportConfig")
+	}
+	defer fd.Close()
+
+	var c ImportConfig
+	if err := json.NewDecoder(fd).Decode(&c); err != nil {
+		return nil, errors.Wrap(err, "LoadImportConfig")
+	}
+
+	return &c, nil
+}
+
+// account resolves a statement account id to a journal account, falling
+// back to an Assets:Unknown:<id> placeholder when the id isn't in c (or c
+// is nil), so an import never silently drops a statement's transactions for
+// want of a config entry.
+func (c *ImportConfig) account(id string) string {
+	if c != nil {
+		if a, ok := c.Accounts[id]; ok {
+			return a
+		}
+	}
+
+	return "Assets:Unknown:" + id
+}
+
+// CSVFormat describes which column of a CSV import holds each field, as
+// given by -csv-format, e.g. "date,amount,description,id" for a 4-column
+// file with the date first. ID is -1 when the format has no id column, in
+// which case a hash of the row is used for dedup instead.
+type CSVFormat struct {
+	Date        int
+	Amount      int
+	Description int
+	ID          int
+	DateLayout  string
+}
+
+// ParseCSVFormat parses a -csv-format column spec (comma-separated names
+// from date, amount, description, id) paired with a Go reference-time
+// layout for the date column.
+func ParseCSVFormat(spec, dateLayout string) (CSVFormat, error) {
+	f := CSVFormat{ID: -1, DateLayout: dateLayout}
+	if f.DateLayout == "" {
+		f.DateLayout = "2006-01-02"
+	}
+
+	for i, name := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(name) {
+		case "date":
+			f.Date = i
+		case "amount":
+			f.Amount = i
+		case "description":
+			f.Description = i
+		case "id":
+			f.ID = i
+		default:
+			return CSVFormat{}, errors.Errorf("ParseCSVFormat: unknown column %q", name)
+		}
+	}
+
+	return f, nil
+}
+
+// ImportCSV reads a generic CSV bank export (as described by format) and
+// returns one Transaction per row, each with a single RealPosting against
+// account; the balancing Expenses:/Income: posting is left for the caller
+// to fill in via applyTriggers.
+func ImportCSV(r io.Reader, format CSVFormat, account string) (Transactions, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "ImportCSV")
+	}
+
+	var out Transactions
+
+	for _, row := range rows {
+		if format.Date >= len(row) || format.Amount >= len(row) || format.Description >= len(row) {
+			return nil, errors.Errorf("ImportCSV: row %q is too short for format %+v", row, format)
+		}
+
+		d, err := time.Parse(format.DateLayout, strings.TrimSpace(row[format.Date]))
+		if err != nil {
+			return nil, errors.Wrap(err, "ImportCSV")
+		}
+
+		amt, err := decimal.NewFromString(strings.TrimSpace(row[format.Amount]))
+		if err != nil {
+			return nil, errors.Wrap(err, "ImportCSV")
+		}
+
+		desc := strings.TrimSpace(row[format.Description])
+
+		id := ""
+		if format.ID >= 0 && format.ID < len(row) {
+			id = strings.TrimSpace(row[format.ID])
+		}
+		if id == "" {
+			id = hashImportRow(d, amt, desc)
+		}
+
+		out = append(out, &Transaction{
+			ID:          id,
+			Date:        d,
+			Description: desc,
+			Postings: []*Posting{{
+				Type:    RealPosting,
+				Account: account,
+				Amount:  &Amount{Quantity: amt},
+				Comment: desc,
+			}},
+		})
+	}
+
+	return out, nil
+}
+
+// hashImportRow identifies a CSV row that doesn't carry its own id, for
+// dedup purposes, by hashing the fields that make it unique.
+func hashImportRow(d time.Time, amt decimal.Decimal, desc string) string {
+	h := sha1.Sum([]byte(d.Format("2006-01-02") + "|" + amt.String() + "|" + desc))
+
+	return fmt.Sprintf("%x", h)
+}
+
+// ImportOFX reads an OFX/QFX bank or credit-card statement and returns one
+// Transaction per <STMTTRN>, mapping each statement's account id to a
+// journal account via cfg.
+func ImportOFX(r io.Reader, cfg *ImportConfig) (Transactions, error) {
+	resp, err := ofxgo.ParseResponse(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "ImportOFX")
+	}
+
+	var out Transactions
+
+	for _, msg := range resp.Bank {
+		stmt, ok := msg.(*ofxgo.StatementResponse)
+		if !ok {
+			continue
+		}
+
+		account := cfg.account(stmt.BankAcctFrom.AcctID.String())
+
+		for _, tx := range stmt.BankTranList.Transactions {
+			out = append(out, ofxTransaction(tx, account))
+		}
+	}
+
+	for _, msg := range resp.CreditCard {
+		stmt, ok := msg.(*ofxgo.CCStatementResponse)
+		if !ok {
+			continue
+		}
+
+		account := cfg.account(stmt.CCAcctFrom.AcctID.String())
+
+		for _, tx := range stmt.BankTranList.Transactions {
+			out = append(out, ofxTransaction(tx, account))
+		}
+	}
+
+	return out, nil
+}
+
+func ofxTransaction(tx ofxgo.Transaction, account string) *Transaction {
+	desc := tx.Name.String()
+	if memo := tx.Memo.String(); memo != "" {
+		desc = strings.TrimSpace(desc + " " + memo)
+	}
+
+	amt, _ := decimal.NewFromString(tx.TrnAmt.String())
+
+	return &Transaction{
+		ID:          tx.FiTID.String(),
+		Date:        tx.DtPosted.Time,
+		Description: desc,
+		Postings: []*Posting{{
+			Type:    RealPosting,
+			Account: account,
+			Amount:  &Amount{Quantity: amt},
+			Comment: desc,
+		}},
+	}
+}
+
+// Dedup removes any imported transaction whose ID matches a transaction
+// already present in existing, so re-running an import against the same
+// statement (or a statement with overlapping dates) doesn't duplicate
+// entries already recorded in the journal passed via -against.
+func Dedup(imported, existing Transactions) Transactions {
+	seen := make(map[string]bool, len(existing))
+	for _, tx := range existing {
+		if tx.ID != "" {
+			seen[tx.ID] = true
+		}
+	}
+
+	var out Transactions
+
+	for _, tx := range imported {
+		if tx.ID != "" && seen[tx.ID] {
+			continue
+		}
+
+		out = append(out, tx)
+	}
+
+	return out
+}
+
+// runImport implements the `import` subcommand: it reads an OFX/QFX or CSV
+// statement, dedups it against -against (if given), runs the result
+// through -against's triggers so the balancing posting gets filled in, and
+// prints the resulting journal entries to stdout.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+
+	var (
+		ofxPath       string
+		csvPath       string
+		csvFormat     string
+		csvDateFormat string
+		configPath    string
+		against       string
+		accountID     string
+	)
+
+	fs.StringVar(&ofxPath, "ofx", "", "OFX/QFX file to import.")
+	fs.StringVar(&csvPath, "csv", "", "CSV file to import.")
+	fs.StringVar(&csvFormat, "csv-format", "date,amount,description,id", "Comma-separated column order for -csv, from: date, amount, description, id.")
+	fs.StringVar(&csvDateFormat, "csv-date-format", "2006-01-02", "Go reference-time layout for the -csv date column.")
+	fs.StringVar(&configPath, "config", "", "JSON config file mapping statement account ids to journal accounts.")
+	fs.StringVar(&against, "against", "", "Existing journal file to dedup against (by transaction ID) and to load triggers from.")
+	fs.StringVar(&accountID, "account", "", "Statement account id to import -csv postings against (looked up in -config).")
+
+	if err := fs.Parse(args); err != nil {
+		return errors.Wrap(err, "runImport")
+	}
+
+	var cfg *ImportConfig
+	if configPath != "" {
+		c, err := LoadImportConfig(configPath)
+		if err != nil {
+			return err
+		}
+
+		cfg = c
+	}
+
+	var imported Transactions
+
+	switch {
+	case ofxPath != "":
+		fd, err := os.Open(ofxPath)
+		if err != nil {
+			return errors.Wrap(err, "runImport")
+		}
+		defer fd.Close()
+
+		if imported, err = ImportOFX(fd, cfg); err != nil {
+			return err
+		}
+	case csvPath != "":
+		fd, err := os.Open(csvPath)
+		if err != nil {
+			return errors.Wrap(err, "runImport")
+		}
+		defer fd.Close()
+
+		format, err := ParseCSVFormat(csvFormat, csvDateFormat)
+		if err != nil {
+			return err
+		}
+
+		if imported, err = ImportCSV(fd, format, cfg.account(accountID)); err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("runImport: one of -ofx or -csv is required")
+	}
+
+	var triggers []*Trigger
+
+	if against != "" {
+		t, existing, _, _, _, _, err := ParseFile(against, false)
+		if err != nil {
+			return errors.Wrap(err, "runImport")
+		}
+
+		triggers = t
+		imported = Dedup(imported, existing)
+	}
+
+	if err := applyTriggers(imported, triggers); err != nil {
+		return err
+	}
+
+	for _, tx := range imported {
+		fmt.Printf("%s\n", tx.String())
+	}
+
+	return nil
+}
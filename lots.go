@@ -0,0 +1,204 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Lot is a single acquisition of a commodity, tracked for FIFO cost-basis
+// matching against later disposals.
+type Lot struct {
+	Date     time.Time
+	Quantity decimal.Decimal
+	UnitCost decimal.Decimal
+	Currency string
+}
+
+// LotAccount identifies the (account, commodity) pair a LotBook's queues
+// are keyed by, e.g. {"Assets:Broker", "AAPL"}.
+type LotAccount struct {
+	Account   string
+	Commodity string
+}
+
+// LotBook holds the open lots for every (account, commodity) pair seen
+// while walking a chronologically-ordered set of transactions.
+type LotBook struct {
+	l sync.Mutex
+	m map[LotAccount][]*Lot
+}
+
+func NewLotBook() *LotBook { return &LotBook{m: make(map[LotAccount][]*Lot)} }
+
+// Acquire records a new lot of quantity units of commodity, bought at
+// unitCost per unit (in currency) in account on date.
+func (b *LotBook) Acquire(account, commodity string, date time.Time, quantity, unitCost decimal.Decimal, currency string) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	k := LotAccount{account, commodity}
+	b.m[k] = append(b.m[k], &Lot{Date: date, Quantity: quantity, UnitCost: unitCost, Currency: currency})
+}
+
+// Dispose matches a disposal of quantity units of commodity from account
+// FIFO against open lots, consuming (and dropping, once exhausted) the
+// oldest lots first. It returns the cost basis consumed and the portion of
+// quantity that couldn't be matched against any open lot (e.g. a disposal
+// recorded before its acquisition).
+func (b *LotBook) Dispose(account, commodity string, quantity decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	k := LotAccount{account, commodity}
+	lots := b.m[k]
+
+	remaining := quantity
+	var costBasis decimal.Decimal
+
+	i := 0
+	for i < len(lots) && remaining.IsPositive() {
+		lot := lots[i]
+
+		matched := lot.Quantity
+		if matched.GreaterThan(remaining) {
+			matched = remaining
+		}
+
+		costBasis = costBasis.Add(matched.Mul(lot.UnitCost))
+		lot.Quantity = lot.Quantity.Sub(matched)
+		remaining = remaining.Sub(matched)
+
+		if lot.Quantity.IsZero() {
+			i++
+		}
+	}
+
+	b.m[k] = lots[i:]
+
+	return costBasis, remaining
+}
+
+// Open returns the still-open lots for account/commodity, oldest first.
+func (b *LotBook) Open(account, commodity string) []*Lot {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	return append([]*Lot(nil), b.m[LotAccount{account, commodity}]...)
+}
+
+// Keys returns every (account, commodity) pair with at least one open lot,
+// sorted by account then commodity.
+func (b *LotBook) Keys() []LotAccount {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	var out []LotAccount
+	for k, lots := range b.m {
+		if len(lots) == 0 {
+			continue
+		}
+
+		out = append(out, k)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Account != out[j].Account {
+			return out[i].Account < out[j].Account
+		}
+
+		return out[i].Commodity < out[j].Commodity
+	})
+
+	return out
+}
+
+// postingTotalPrice returns the total price (in the posting's Price
+// commodity) that a @ or @@ annotated posting of qty units represents, and
+// whether the posting carries one at all.
+func postingTotalPrice(p *Posting, qty decimal.Decimal) (decimal.Decimal, string, bool) {
+	if p.Price == nil {
+		return decimal.Decimal{}, "", false
+	}
+
+	switch p.PriceType {
+	case UnitPrice:
+		return qty.Mul(p.Price.Quantity), p.Price.Commodity, true
+	case TotalPrice:
+		total := p.Price.Quantity
+		if qty.IsNegative() {
+			total = total.Neg()
+		}
+		return total, p.Price.Commodity, true
+	default:
+		return decimal.Decimal{}, "", false
+	}
+}
+
+// ProcessLots walks transactions (which must already be sorted
+// chronologically) and matches every `@`/`@@`-priced posting against lots:
+// an acquisition (positive quantity) opens a new lot, and a disposal
+// (negative quantity) is matched FIFO against open lots for the same
+// account/commodity, appending a virtual realised gain/loss posting whose
+// amount is the negation of proceeds minus the cost basis consumed (so a
+// gain is a credit, i.e. negative, on the gain account). The gain posting
+// is tagged GeneratedBy = -2 with From pointing at its disposal posting
+// (and is itself skipped on the way in), and a disposal that already has
+// one gets no second, so running this more than once over the same
+// transactions doesn't double up gains.
+// gainAccount resolves the account a disposal's gain posting should be
+// addressed to, e.g. Context.LotGainAccount.
+func ProcessLots(transactions Transactions, gainAccount func(account string) string, lots *LotBook) {
+	for _, tx := range transactions {
+		for i := 0; i < len(tx.Postings); i++ {
+			p := tx.Postings[i]
+
+			if p.GeneratedBy == -2 || p.Amount == nil {
+				continue
+			}
+
+			switch {
+			case p.Amount.Quantity.IsPositive():
+				total, currency, ok := postingTotalPrice(p, p.Amount.Quantity)
+				if !ok {
+					continue
+				}
+
+				unitCost := total.Div(p.Amount.Quantity)
+
+				lots.Acquire(p.Account, p.Amount.Commodity, tx.Date, p.Amount.Quantity, unitCost, currency)
+			case p.Amount.Quantity.IsNegative():
+				qty := p.Amount.Quantity.Neg()
+
+				proceeds, currency, ok := postingTotalPrice(p, qty)
+				if !ok {
+					continue
+				}
+
+				costBasis, _ := lots.Dispose(p.Account, p.Amount.Commodity, qty)
+				gain := proceeds.Sub(costBasis)
+
+				recorded := false
+				for _, gp := range tx.Postings {
+					if gp.GeneratedBy == -2 && gp.From == i+1 {
+						recorded = true
+						break
+					}
+				}
+				if recorded {
+					continue
+				}
+
+				tx.Postings = append(tx.Postings, &Posting{
+					Type:        VirtualPosting,
+					Account:     gainAccount(p.Account),
+					Amount:      &Amount{Quantity: gain.Neg(), Commodity: currency},
+					GeneratedBy: -2,
+					From:        i + 1,
+				})
+			}
+		}
+	}
+}
@@ -1,60 +1,83 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"os"
-	"regexp"
 	"sort"
+	"time"
+
+	"github.com/deoxxa/accounting/query"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 )
 
 var (
-	file        string
-	mode        string
-	account     string
-	transaction string
-	showZero    bool
-	onlyReal    bool
-	noBalance   bool
-	noTriggers  bool
-	noSort      bool
+	file            string
+	mode            string
+	queryExpr       string
+	showZero        bool
+	onlyReal        bool
+	noBalance       bool
+	noTriggers      bool
+	noSort          bool
+	reportCommodity string
+	strict          bool
+	forecast        bool
+	forecastTo      string
+	perPeriod       bool
+	noLots          bool
 )
 
 func init() {
 	flag.StringVar(&file, "file", "log.txt", "Ledger file to process.")
-	flag.StringVar(&mode, "mode", "balance", "Mode to run in (balance, print, or register).")
-	flag.StringVar(&account, "account", "", "Show only accounts matching this regex filter.")
-	flag.StringVar(&transaction, "transaction", "", "Show only transactions matching this regex filter for their description or ID.")
+	flag.StringVar(&mode, "mode", "balance", "Mode to run in (balance, print, register, budget, or lots).")
+	flag.StringVar(&queryExpr, "query", "", "Filter postings/transactions with a query expression, e.g. 'acct:^Assets date:>=2024-01-01 amt:<0'.")
 	flag.BoolVar(&showZero, "show_zero", false, "Show entries where the balance or amount is zero.")
 	flag.BoolVar(&onlyReal, "only_real", false, "Only use real postings, not virtual.")
 	flag.BoolVar(&noBalance, "no_balance", false, "Don't perform or check balancing (only really useful with print).")
 	flag.BoolVar(&noTriggers, "no_triggers", false, "Don't run any triggers (only really useful with print).")
 	flag.BoolVar(&noSort, "no_sort", false, "Don't re-order transactions by date.")
+	flag.StringVar(&reportCommodity, "report-commodity", "", "Convert all balances into this commodity at the latest known rate before displaying them.")
+	flag.BoolVar(&strict, "strict", false, "Error on any posting account not declared with an 'account' directive, once at least one has been declared.")
+	flag.BoolVar(&forecast, "forecast", false, "Include generated instances of periodic ('~') transactions in register/balance output.")
+	flag.StringVar(&forecastTo, "forecast-to", "", "Expand periodic transactions up to this date (YYYY-MM-DD). Defaults to today.")
+	flag.BoolVar(&perPeriod, "period", false, "In -mode=budget, print one block per budget period instead of a single totals block.")
+	flag.BoolVar(&noLots, "no_lots", false, "Don't match @/@@-priced postings against lots or generate realised gain/loss postings.")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	flag.Parse()
 
-	fd, err := os.Open(file)
+	q, err := query.Parse(queryExpr)
 	if err != nil {
 		panic(err)
 	}
-	defer fd.Close()
 
-	var accountRegexp *regexp.Regexp
-	if account != "" {
-		accountRegexp = regexp.MustCompile(account)
+	triggers, transactions, prices, periodics, budgets, ctx, err := ParseFile(file, strict)
+	if err != nil {
+		panic(err)
 	}
 
-	var transactionRegexp *regexp.Regexp
-	if transaction != "" {
-		transactionRegexp = regexp.MustCompile(transaction)
+	until := time.Now()
+	if forecastTo != "" {
+		until, err = time.Parse("2006-01-02", forecastTo)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	triggers, transactions, err := parseFile(bufio.NewReader(fd))
-	if err != nil {
-		panic(err)
+	if forecast {
+		transactions = append(transactions, ExpandPeriodic(periodics, earliestDate(transactions), until, transactions)...)
 	}
 
 	if !noSort {
@@ -62,37 +85,18 @@ func main() {
 	}
 
 	if noTriggers == false {
-		for _, tx := range transactions {
-			for i := 0; i < len(tx.Postings); i++ {
-				p := tx.Postings[i]
-
-				for _, tr := range triggers {
-					if p.GeneratedBy == tr.ID {
-						continue
-					}
-
-					b, m := tr.Match(tx, p)
-					if !b {
-						continue
-					}
-
-					for _, a := range tr.Actions {
-						pp := a.Execute(p, m)
-						pp.GeneratedBy = tr.ID
-						pp.From = i + 1
-						tx.Postings = append(tx.Postings, pp)
-					}
-				}
-
-				if len(tx.Postings) > 100 {
-					fmt.Printf("posting cycle detected\n\n%s\n", tx.String())
+		if err := applyTriggers(transactions, triggers); err != nil {
+			fmt.Printf("%s\n", err.Error())
 
-					os.Exit(1)
-				}
-			}
+			os.Exit(1)
 		}
 	}
 
+	lots := NewLotBook()
+	if !noLots {
+		ProcessLots(transactions, ctx.LotGainAccount, lots)
+	}
+
 	if noBalance == false {
 		failed := false
 
@@ -101,6 +105,7 @@ func main() {
 				fmt.Printf("%s\n\n%s\n", err.Error(), tx.String())
 
 				failed = true
+				continue
 			}
 
 			if err := tx.Balance(); err != nil {
@@ -121,7 +126,16 @@ func main() {
 			fmt.Printf("%s\n", tr.String())
 		}
 		for _, tx := range transactions {
-			if transactionRegexp != nil && !transactionRegexp.MatchString(tx.Description) && !transactionRegexp.MatchString(tx.ID) {
+			matched := q == nil || len(tx.Postings) == 0
+
+			for _, p := range tx.Postings {
+				if query.Match(q, tx, p) {
+					matched = true
+					break
+				}
+			}
+
+			if !matched {
 				continue
 			}
 
@@ -140,24 +154,21 @@ func main() {
 				if p.Type != RealPosting && onlyReal {
 					continue
 				}
-
-				a := accounts.Get(p.Account)
-				a.Add(*p.Amount)
-
-				if accountRegexp != nil && !accountRegexp.MatchString(a.Name) {
-					continue
-				}
-				if transactionRegexp != nil && !transactionRegexp.MatchString(tx.Description) && !transactionRegexp.MatchString(tx.ID) {
+				if !query.Match(q, tx, p) {
 					continue
 				}
 
+				a := accounts.Get(p.Account)
+				amt := reportAmount(prices, *p.Amount, tx.Date)
+				a.Add(amt)
+
 				prefix := ""
 				if first {
 					prefix = fmt.Sprintf("%s %-30s", tx.Date.Format("06-Jan-02"), tx.Description)
 					first = false
 				}
 
-				fmt.Printf("%-42s %-40s %14s %14s\n", prefix, a.Name, "$"+p.Amount.StringFixedBank(2), "$"+a.Balance.StringFixedBank(2))
+				fmt.Printf("%-42s %-40s %14s %14s\n", prefix, a.Name, amt.String(), formatBalances(a.Balances))
 			}
 		}
 	case "balance":
@@ -171,8 +182,11 @@ func main() {
 				if p.Type != RealPosting && onlyReal {
 					continue
 				}
+				if !query.Match(q, tx, p) {
+					continue
+				}
 
-				accounts.Get(p.Account).Add(*p.Amount)
+				accounts.Get(p.Account).Add(reportAmount(prices, *p.Amount, tx.Date))
 			}
 		}
 
@@ -182,16 +196,142 @@ func main() {
 		for _, name := range names {
 			a := accounts.Get(name)
 
-			if a.Balance.IsZero() && !showZero {
+			if isZeroBalances(a.Balances) && !showZero {
 				continue
 			}
 
-			if accountRegexp == nil || accountRegexp.MatchString(a.Name) {
-				fmt.Printf("%16s %-40s\n", "$"+a.Balance.StringFixedBank(2), a.Name)
-			}
+			fmt.Printf("%16s %-40s\n", formatBalances(a.Balances), a.Name)
 		}
 
 		fmt.Printf("---------------- Total\n")
-		fmt.Printf("%16s\n", "$"+accounts.Balance().StringFixedBank(2))
+		fmt.Printf("%16s\n", formatBalances(accounts.Balance()))
+	case "budget":
+		for _, r := range EvaluateBudget(budgets, transactions, until, perPeriod) {
+			if perPeriod {
+				fmt.Printf("%s - %s\n", r.Start.Format("2006-01-02"), r.End.Format("2006-01-02"))
+			}
+
+			for _, a := range r.Accounts {
+				if a.Actual.IsZero() && a.Budgeted.IsZero() && !showZero {
+					continue
+				}
+
+				fmt.Printf("%-40s %14s %14s %14s %6.1f%%\n", a.Account, a.Actual.String(), a.Budgeted.String(), a.Variance().String(), a.PercentUsed())
+			}
+
+			fmt.Printf("\n")
+		}
+	case "lots":
+		for _, k := range lots.Keys() {
+			for _, lot := range lots.Open(k.Account, k.Commodity) {
+				unrealised := "?"
+
+				if p, ok := prices.Latest(k.Commodity, time.Now()); ok && p.Price.Commodity == lot.Currency {
+					unrealised = lot.Quantity.Mul(p.Price.Quantity.Sub(lot.UnitCost)).String()
+				}
+
+				fmt.Printf("%-40s %14s %-6s %14s %-6s %14s\n", k.Account, lot.Quantity.String(), k.Commodity, lot.UnitCost.String(), lot.Currency, unrealised)
+			}
+		}
+	}
+}
+
+// applyTriggers runs every posting in transactions through triggers,
+// appending the postings each match's actions generate (e.g. filling in an
+// imported transaction's balancing Expenses:/Income: posting). It's used by
+// both the default balance/print/register flow and the import subcommand.
+func applyTriggers(transactions Transactions, triggers []*Trigger) error {
+	for _, tx := range transactions {
+		for i := 0; i < len(tx.Postings); i++ {
+			p := tx.Postings[i]
+
+			for _, tr := range triggers {
+				if p.GeneratedBy == tr.ID {
+					continue
+				}
+
+				b, m := tr.Match(tx, p)
+				if !b {
+					continue
+				}
+
+				for _, a := range tr.Actions {
+					pp := a.Execute(p, m)
+					pp.GeneratedBy = tr.ID
+					pp.From = i + 1
+					tx.Postings = append(tx.Postings, pp)
+				}
+			}
+
+			if len(tx.Postings) > 100 {
+				return errors.Errorf("posting cycle detected\n\n%s", tx.String())
+			}
+		}
+	}
+
+	return nil
+}
+
+// reportAmount converts amt into the -report-commodity commodity (if set)
+// at its latest known rate as of asOf, leaving it untouched otherwise.
+func reportAmount(prices *PriceBook, amt Amount, asOf time.Time) Amount {
+	if reportCommodity == "" {
+		return amt
+	}
+
+	return prices.Convert(amt, reportCommodity, asOf)
+}
+
+// earliestDate returns the earliest transaction date in txs, or the zero
+// time if there aren't any, used as the default start for periodic
+// transactions that don't declare their own via 'from'/'on'.
+func earliestDate(txs Transactions) time.Time {
+	var e time.Time
+
+	for _, tx := range txs {
+		if e.IsZero() || tx.Date.Before(e) {
+			e = tx.Date
+		}
+	}
+
+	return e
+}
+
+func isZeroBalances(m map[string]decimal.Decimal) bool {
+	for _, b := range m {
+		if !b.IsZero() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// formatBalances renders a per-commodity balance map as a space-separated
+// list of amounts, one per non-zero commodity (every commodity with
+// -show_zero), sorted by commodity, or "0" when there are none.
+func formatBalances(m map[string]decimal.Decimal) string {
+	var commodities []string
+	for c, b := range m {
+		if b.IsZero() && !showZero {
+			continue
+		}
+		commodities = append(commodities, c)
+	}
+	sort.Strings(commodities)
+
+	if len(commodities) == 0 {
+		return "0"
 	}
+
+	var s string
+	for i, c := range commodities {
+		if i > 0 {
+			s += ", "
+		}
+
+		s += Amount{Quantity: m[c], Commodity: c}.String()
+	}
+
+	return s
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -25,19 +26,22 @@ func (a *Accounts) Get(name string) *Account {
 
 	if _, ok := a.m[name]; !ok {
 		a.m[name] = &Account{
-			Name:    name,
-			Balance: decimal.NewFromFloat(0),
+			Name:     name,
+			Balances: make(map[string]decimal.Decimal),
 		}
 	}
 
 	return a.m[name]
 }
 
-func (a *Accounts) Balance() decimal.Decimal {
-	var d decimal.Decimal
+// Balance returns the total balance per commodity across every account.
+func (a *Accounts) Balance() map[string]decimal.Decimal {
+	d := make(map[string]decimal.Decimal)
 
 	for _, e := range a.m {
-		d = d.Add(e.Balance)
+		for c, b := range e.Balances {
+			d[c] = d[c].Add(b)
+		}
 	}
 
 	return d
@@ -65,12 +69,120 @@ func (a *Accounts) Filter(prefix string) *Accounts {
 }
 
 type Account struct {
-	Name    string
-	Balance decimal.Decimal
+	Name     string
+	Balances map[string]decimal.Decimal
+}
+
+func (a *Account) Add(amt Amount) {
+	if a.Balances == nil {
+		a.Balances = make(map[string]decimal.Decimal)
+	}
+
+	a.Balances[amt.Commodity] = a.Balances[amt.Commodity].Add(amt.Quantity)
+}
+
+// Amount represents a quantity of a particular commodity, e.g. "100.00 USD"
+// or "10 AAPL". An empty Commodity means the amount is commodity-less (as
+// produced by plain arithmetic before a commodity has been assigned).
+type Amount struct {
+	Quantity  decimal.Decimal
+	Commodity string
+}
+
+func (a Amount) String() string {
+	if a.Commodity == "" {
+		return a.Quantity.String()
+	}
+
+	return a.Quantity.String() + " " + a.Commodity
 }
 
-func (a *Account) Add(d decimal.Decimal) {
-	a.Balance = a.Balance.Add(d)
+func (a Amount) IsZero() bool { return a.Quantity.IsZero() }
+
+func (a Amount) Neg() Amount { return Amount{Quantity: a.Quantity.Neg(), Commodity: a.Commodity} }
+
+func (a Amount) Mul(d decimal.Decimal) Amount {
+	return Amount{Quantity: a.Quantity.Mul(d), Commodity: a.Commodity}
+}
+
+// Price records a historical exchange rate declared by a `P` directive, e.g.
+// `P 2024-01-01 EUR $1.10` (1 EUR is worth 1.10 USD on that date).
+type Price struct {
+	Date      time.Time
+	Commodity string
+	Price     Amount
+}
+
+// PriceBook holds every known Price, indexed by commodity and sorted by
+// date, so that conversions can look up the latest known rate.
+type PriceBook struct {
+	l sync.Mutex
+	m map[string][]Price
+}
+
+func NewPriceBook() *PriceBook { return &PriceBook{m: make(map[string][]Price)} }
+
+// Merge adds every price from other into b.
+func (b *PriceBook) Merge(other *PriceBook) {
+	other.l.Lock()
+	defer other.l.Unlock()
+
+	for _, l := range other.m {
+		for _, p := range l {
+			b.Add(p)
+		}
+	}
+}
+
+func (b *PriceBook) Add(p Price) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	l := b.m[p.Commodity]
+	i := sort.Search(len(l), func(i int) bool { return l[i].Date.After(p.Date) })
+	l = append(l, Price{})
+	copy(l[i+1:], l[i:])
+	l[i] = p
+	b.m[p.Commodity] = l
+}
+
+// Latest returns the most recent known price for commodity at or before
+// asOf, and whether one was found.
+func (b *PriceBook) Latest(commodity string, asOf time.Time) (Price, bool) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	l := b.m[commodity]
+
+	var best Price
+	var found bool
+
+	for _, p := range l {
+		if p.Date.After(asOf) {
+			break
+		}
+
+		best = p
+		found = true
+	}
+
+	return best, found
+}
+
+// Convert converts amt into targetCommodity using the latest known rate,
+// returning the original amount unconverted if no rate is known or the
+// amount is already in the target commodity.
+func (b *PriceBook) Convert(amt Amount, targetCommodity string, asOf time.Time) Amount {
+	if amt.Commodity == targetCommodity || amt.Commodity == "" {
+		return amt
+	}
+
+	p, ok := b.Latest(amt.Commodity, asOf)
+	if !ok || p.Price.Commodity != targetCommodity {
+		return amt
+	}
+
+	return Amount{Quantity: amt.Quantity.Mul(p.Price.Quantity), Commodity: targetCommodity}
 }
 
 type Transactions []*Transaction
@@ -80,13 +192,20 @@ func (l Transactions) Less(a, b int) bool { return l[a].Date.Before(l[b].Date) }
 func (l Transactions) Swap(a, b int)      { l[a], l[b] = l[b], l[a] }
 
 type Transaction struct {
-	Date        time.Time
-	Description string
-	Postings    []*Posting
+	ID            string
+	Date          time.Time
+	SecondaryDate *time.Time
+	Description   string
+	Postings      []*Posting
 }
 
 func (t Transaction) String() string {
-	s := fmt.Sprintf("%s %s\n", t.Date.Format("2006-01-02"), t.Description)
+	hdr := t.Date.Format("2006-01-02")
+	if t.ID != "" {
+		hdr += fmt.Sprintf(" <%s>", t.ID)
+	}
+
+	s := fmt.Sprintf("%s %s\n", hdr, t.Description)
 	for _, e := range t.Postings {
 		s += "\t" + e.String() + "\n"
 	}
@@ -94,10 +213,16 @@ func (t Transaction) String() string {
 	return s
 }
 
+// AutoBalance fills in the amount of a single elided posting so that the
+// transaction balances. Since amounts now carry a commodity, the elided
+// posting's commodity is inferred from whichever commodity the rest of the
+// postings don't already balance to; an elision across more than one
+// unbalanced commodity is ambiguous and is rejected.
 func (t *Transaction) AutoBalance() error {
 	var toFill *Posting
 
-	var total decimal.Decimal
+	totals := make(map[string]decimal.Decimal)
+	var order []string
 
 	for _, e := range t.Postings {
 		if e.Amount == nil {
@@ -106,32 +231,92 @@ func (t *Transaction) AutoBalance() error {
 			}
 
 			toFill = e
-		} else {
-			total = total.Add(*e.Amount)
+
+			continue
+		}
+
+		if e.Type == VirtualPosting {
+			continue
+		}
+
+		commodity, quantity := e.Amount.Commodity, e.Amount.Quantity
+		if total, currency, ok := postingTotalPrice(e, quantity); ok {
+			commodity, quantity = currency, total
+		}
+
+		if _, ok := totals[commodity]; !ok {
+			order = append(order, commodity)
 		}
+		totals[commodity] = totals[commodity].Add(quantity)
 	}
 
-	if toFill != nil {
-		total = total.Neg()
-		toFill.Amount = &total
+	if toFill == nil {
+		return nil
 	}
 
+	commodity := ""
+	unbalanced := 0
+
+	for _, c := range order {
+		if !totals[c].IsZero() {
+			commodity = c
+			unbalanced++
+		}
+	}
+
+	switch {
+	case unbalanced > 1:
+		return errors.Errorf("AutoBalance: elided posting is ambiguous across multiple commodities")
+	case unbalanced == 0 && len(order) == 1:
+		commodity = order[0]
+	}
+
+	toFill.Amount = &Amount{Quantity: totals[commodity].Neg(), Commodity: commodity}
+
 	return nil
 }
 
+// Balance checks that real (non-virtual) postings sum to zero, per
+// commodity; a transaction mixing USD and AAPL postings, say, must balance
+// its USD postings to zero and its AAPL postings to zero independently. A
+// posting carrying an `@`/`@@` price balances against its cost instead of
+// its own commodity (see postingTotalPrice): its total price is what
+// contributes to the price commodity's balance, so e.g.
+// `Assets:Broker 10 AAPL @ $150.00` / `Assets:Checking -$1500` balances in
+// USD, with the AAPL leg left to lot tracking rather than this check.
 func (t *Transaction) Balance() error {
-	var total decimal.Decimal
+	totals := make(map[string]decimal.Decimal)
+	var order []string
+
+	add := func(commodity string, amount decimal.Decimal) {
+		if _, ok := totals[commodity]; !ok {
+			order = append(order, commodity)
+		}
+		totals[commodity] = totals[commodity].Add(amount)
+	}
 
 	for _, e := range t.Postings {
-		if e.Type == VirtualPosting {
+		if e.Type == VirtualPosting || e.Amount == nil {
+			continue
+		}
+
+		if total, currency, ok := postingTotalPrice(e, e.Amount.Quantity); ok {
+			add(currency, total)
 			continue
 		}
 
-		total = total.Add(*e.Amount)
+		add(e.Amount.Commodity, e.Amount.Quantity)
 	}
 
-	if !total.IsZero() {
-		return errors.Errorf("Balance: transactions must balance to zero; instead got %s", total.String())
+	for _, c := range order {
+		if !totals[c].IsZero() {
+			name := c
+			if name == "" {
+				name = "(none)"
+			}
+
+			return errors.Errorf("Balance: transactions must balance to zero per-commodity; %s instead got %s", name, totals[c].String())
+		}
 	}
 
 	return nil
@@ -156,10 +341,24 @@ func (p PostingType) Format(name string) string {
 	}
 }
 
+// PriceType distinguishes the two `@`/`@@` price annotations a posting may
+// carry: a per-unit price (10 AAPL @ $150.25) or a total price for the whole
+// posting (10 AAPL @@ $1502.50).
+type PriceType int
+
+const (
+	NoPrice PriceType = iota
+	UnitPrice
+	TotalPrice
+)
+
 type Posting struct {
 	Type        PostingType
 	Account     string
-	Amount      *decimal.Decimal
+	Amount      *Amount
+	PriceType   PriceType
+	Price       *Amount
+	Tags        map[string]string
 	Comment     string
 	GeneratedBy int
 	From        int
@@ -170,6 +369,14 @@ func (p Posting) String() string {
 	if p.Amount != nil {
 		s += "\t" + p.Amount.String()
 	}
+	if p.Price != nil {
+		switch p.PriceType {
+		case UnitPrice:
+			s += " @ " + p.Price.String()
+		case TotalPrice:
+			s += " @@ " + p.Price.String()
+		}
+	}
 	if p.Comment != "" {
 		s += "; " + p.Comment
 	}
@@ -312,3 +519,23 @@ func (m *JSMatcher) Match(tx *Transaction, p *Posting) (bool, map[string]string)
 
 	panic(errors.Errorf("can't interpret return type %s", res.Class()))
 }
+
+// The QueryXxx methods below adapt Transaction/Posting to the
+// query.Transaction/query.Posting interfaces, so the query package can
+// match against them without importing package main.
+
+func (t *Transaction) QueryDate() time.Time      { return t.Date }
+func (t *Transaction) QueryDescription() string  { return t.Description }
+func (t *Transaction) QueryID() string           { return t.ID }
+
+func (p *Posting) QueryAccount() string { return p.Account }
+
+func (p *Posting) QueryAmount() (decimal.Decimal, string, bool) {
+	if p.Amount == nil {
+		return decimal.Decimal{}, "", false
+	}
+
+	return p.Amount.Quantity, p.Amount.Commodity, true
+}
+
+func (p *Posting) QueryTags() map[string]string { return p.Tags }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func amount(q string, commodity string) *Amount {
+	return &Amount{Quantity: decimal.RequireFromString(q), Commodity: commodity}
+}
+
+func TestTransactionBalanceSimple(t *testing.T) {
+	tx := &Transaction{
+		Postings: []*Posting{
+			{Account: "Expenses:Food", Amount: amount("50.00", "USD")},
+			{Account: "Assets:Checking", Amount: amount("-50.00", "USD")},
+		},
+	}
+
+	if err := tx.Balance(); err != nil {
+		t.Fatalf("Balance: unexpected error: %v", err)
+	}
+}
+
+func TestTransactionBalanceUnbalanced(t *testing.T) {
+	tx := &Transaction{
+		Postings: []*Posting{
+			{Account: "Expenses:Food", Amount: amount("50.00", "USD")},
+			{Account: "Assets:Checking", Amount: amount("-40.00", "USD")},
+		},
+	}
+
+	if err := tx.Balance(); err == nil {
+		t.Fatal("Balance: expected error, got none")
+	}
+}
+
+func TestTransactionBalanceUnitPrice(t *testing.T) {
+	tx := &Transaction{
+		Postings: []*Posting{
+			{
+				Account:   "Assets:Broker",
+				Amount:    amount("10", "AAPL"),
+				PriceType: UnitPrice,
+				Price:     amount("150.00", "USD"),
+			},
+			{Account: "Assets:Checking", Amount: amount("-1500.00", "USD")},
+		},
+	}
+
+	if err := tx.Balance(); err != nil {
+		t.Fatalf("Balance: unexpected error: %v", err)
+	}
+}
+
+func TestTransactionBalanceTotalPriceDisposal(t *testing.T) {
+	tx := &Transaction{
+		Postings: []*Posting{
+			{
+				Account:   "Assets:Broker",
+				Amount:    amount("-10", "AAPL"),
+				PriceType: TotalPrice,
+				Price:     amount("1600.00", "USD"),
+			},
+			{Account: "Assets:Checking", Amount: amount("1600.00", "USD")},
+		},
+	}
+
+	if err := tx.Balance(); err != nil {
+		t.Fatalf("Balance: unexpected error: %v", err)
+	}
+}
+
+// TestTransactionBalanceNilAmount guards against a panic when a posting's
+// Amount is left nil, e.g. by a failed AutoBalance.
+func TestTransactionBalanceNilAmount(t *testing.T) {
+	tx := &Transaction{
+		Postings: []*Posting{
+			{Account: "Expenses:Food", Amount: nil},
+			{Account: "Assets:Checking", Amount: amount("-50.00", "USD")},
+		},
+	}
+
+	if err := tx.Balance(); err == nil {
+		t.Fatal("Balance: expected error, got none")
+	}
+}
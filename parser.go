@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,9 +24,34 @@ func getComment(s string) (string, string) {
 	return s, ""
 }
 
-func parseFile(rd *bufio.Reader) ([]*Trigger, Transactions, error) {
+// ParseFile parses the journal at path, following any `include` directives
+// it contains, and returns everything it found alongside the Context that
+// accumulated while parsing (aliases, declared accounts, default
+// commodity). It's the entry point main uses, and is exposed standalone so
+// callers can exercise parsing (and inspect the resulting Context) directly.
+func ParseFile(path string, strict bool) ([]*Trigger, Transactions, *PriceBook, []*PeriodicTransaction, []*Budget, *Context, error) {
+	ctx := NewContext(path)
+	ctx.Strict = strict
+
+	triggers, transactions, prices, periodics, budgets, err := parseFile(path, ctx)
+
+	return triggers, transactions, prices, periodics, budgets, ctx, err
+}
+
+func parseFile(path string, ctx *Context) ([]*Trigger, Transactions, *PriceBook, []*PeriodicTransaction, []*Budget, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, nil, nil, errors.Wrap(err, "parseFile")
+	}
+	defer fd.Close()
+
+	rd := bufio.NewReader(fd)
+
 	var triggers []*Trigger
 	var transactions Transactions
+	var periodics []*PeriodicTransaction
+	var budgets []*Budget
+	prices := NewPriceBook()
 
 	for {
 		b, err := rd.Peek(1)
@@ -31,28 +59,382 @@ func parseFile(rd *bufio.Reader) ([]*Trigger, Transactions, error) {
 			break
 		}
 
-		switch string(b) {
-		case "\n":
+		switch {
+		case string(b) == "\n":
 			_, _ = rd.Discard(1)
-		case "#":
+		case string(b) == "#":
 			_, _ = rd.ReadString('\n')
-		case "=":
+		case string(b) == "=":
 			t, err := parseTrigger(rd)
 			if err != nil {
-				return nil, nil, errors.Wrap(err, "parseFile")
+				return nil, nil, nil, nil, nil, errors.Wrap(err, "parseFile")
 			}
 			t.ID = len(triggers) + 1
 			triggers = append(triggers, t)
+		case string(b) == "P":
+			p, err := parsePrice(rd)
+			if err != nil {
+				return nil, nil, nil, nil, nil, errors.Wrap(err, "parseFile")
+			}
+			prices.Add(p)
+		case string(b) == "~":
+			pt, err := parsePeriodic(rd, ctx)
+			if err != nil {
+				return nil, nil, nil, nil, nil, errors.Wrap(err, "parseFile")
+			}
+			periodics = append(periodics, pt)
+		case peekHasPrefix(rd, "budget "):
+			bd, err := parseBudget(rd, ctx)
+			if err != nil {
+				return nil, nil, nil, nil, nil, errors.Wrap(err, "parseFile")
+			}
+			budgets = append(budgets, bd)
+		case peekHasPrefix(rd, "include "):
+			it, itx, ip, ipt, ibd, err := parseInclude(rd, ctx)
+			if err != nil {
+				return nil, nil, nil, nil, nil, errors.Wrap(err, "parseFile")
+			}
+			triggers = append(triggers, it...)
+			transactions = append(transactions, itx...)
+			periodics = append(periodics, ipt...)
+			budgets = append(budgets, ibd...)
+			prices.Merge(ip)
+		case peekHasPrefix(rd, "alias "):
+			l, _ := rd.ReadString('\n')
+			l = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), "alias"))
+
+			al, err := parseAlias(l)
+			if err != nil {
+				return nil, nil, nil, nil, nil, errors.Wrap(err, "parseFile")
+			}
+
+			ctx.Aliases = append(ctx.Aliases, al)
+		case peekHasPrefix(rd, "account "):
+			l, _ := rd.ReadString('\n')
+			name := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), "account"))
+
+			ctx.Accounts[name] = true
+		case peekHasPrefix(rd, "D "):
+			l, _ := rd.ReadString('\n')
+			s := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), "D"))
+
+			amt, err := parseAmount(s)
+			if err != nil {
+				return nil, nil, nil, nil, nil, errors.Wrap(err, "parseFile")
+			}
+
+			ctx.DefaultCommodity = amt.Commodity
+		case peekHasPrefix(rd, "lotgain "):
+			l, _ := rd.ReadString('\n')
+			l = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), "lotgain"))
+
+			a := strings.Fields(l)
+			if len(a) != 2 {
+				return nil, nil, nil, nil, nil, errors.Errorf("parseFile: expected 'lotgain ACCOUNT GAINACCOUNT', got %q", l)
+			}
+
+			ctx.LotGainAccounts[ctx.ApplyAlias(a[0])] = ctx.ApplyAlias(a[1])
 		default:
-			t, err := parseTransaction(rd)
+			t, err := parseTransaction(rd, ctx)
 			if err != nil {
-				return nil, nil, errors.Wrap(err, "parseFile")
+				return nil, nil, nil, nil, nil, errors.Wrap(err, "parseFile")
 			}
 			transactions = append(transactions, t)
 		}
 	}
 
-	return triggers, transactions, nil
+	return triggers, transactions, prices, periodics, budgets, nil
+}
+
+// peekHasPrefix reports whether the next bytes in rd equal prefix, without
+// consuming them.
+func peekHasPrefix(rd *bufio.Reader, prefix string) bool {
+	b, err := rd.Peek(len(prefix))
+	if err != nil {
+		return false
+	}
+
+	return string(b) == prefix
+}
+
+// parseInclude handles an `include <path>` directive: the path is resolved
+// relative to the including file and parsed recursively, sharing ctx's
+// included-file set so cycles are rejected.
+func parseInclude(rd *bufio.Reader, ctx *Context) ([]*Trigger, Transactions, *PriceBook, []*PeriodicTransaction, []*Budget, error) {
+	l, err := rd.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, nil, nil, nil, nil, errors.Wrap(err, "parseInclude")
+	}
+
+	rel := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), "include"))
+
+	path := rel
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(ctx.File), rel)
+	}
+
+	if ctx.Included[path] {
+		return nil, nil, nil, nil, nil, errors.Errorf("parseInclude: cycle detected including %q", path)
+	}
+	ctx.Included[path] = true
+
+	parentFile := ctx.File
+	ctx.File = path
+	defer func() { ctx.File = parentFile }()
+
+	return parseFile(path, ctx)
+}
+
+// parseAlias parses the body of an `alias SRC = DST` or
+// `alias /regex/ = replacement` directive (with the leading `alias` keyword
+// already stripped).
+func parseAlias(l string) (Alias, error) {
+	a := strings.SplitN(l, "=", 2)
+	if len(a) != 2 {
+		return Alias{}, errors.Errorf("parseAlias: expected 'SRC = DST', got %q", l)
+	}
+
+	src := strings.TrimSpace(a[0])
+	dst := strings.TrimSpace(a[1])
+
+	if strings.HasPrefix(src, "/") && strings.HasSuffix(src, "/") {
+		re, err := regexp.Compile(strings.Trim(src, "/"))
+		if err != nil {
+			return Alias{}, errors.Wrap(err, "parseAlias")
+		}
+
+		return Alias{Regexp: re, To: dst}, nil
+	}
+
+	return Alias{From: src, To: dst}, nil
+}
+
+// parsePrice parses a `P YYYY-MM-DD COMMODITY PRICE` historical exchange
+// rate directive, e.g. `P 2024-01-01 EUR $1.10`.
+func parsePrice(rd *bufio.Reader) (Price, error) {
+	l, err := rd.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return Price{}, errors.Wrap(err, "parsePrice")
+	}
+
+	l = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), "P"))
+
+	a := strings.SplitN(l, " ", 3)
+	if len(a) != 3 {
+		return Price{}, errors.Errorf("parsePrice: expected 'P DATE COMMODITY PRICE', got %q", l)
+	}
+
+	date, err := time.Parse("2006-01-02", a[0])
+	if err != nil {
+		return Price{}, errors.Wrap(err, "parsePrice")
+	}
+
+	price, err := parseAmount(a[2])
+	if err != nil {
+		return Price{}, errors.Wrap(err, "parsePrice")
+	}
+
+	return Price{Date: date, Commodity: a[1], Price: *price}, nil
+}
+
+// parsePeriodic parses a `~ <period-expression> [description]` directive
+// and the indented postings that follow it, e.g.:
+//
+//	~ monthly from 2024-01-01 to 2024-12-31 Rent
+//		Expenses:Rent	$1500
+//		Assets:Checking	-$1500
+func parsePeriodic(rd *bufio.Reader, ctx *Context) (*PeriodicTransaction, error) {
+	hdr, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "parsePeriodic")
+	}
+	hdr = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(hdr), "~"))
+
+	period, description, err := parsePeriod(hdr)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsePeriodic")
+	}
+
+	pt := &PeriodicTransaction{Period: period, Description: description}
+
+	for {
+		l, err := rd.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			break
+		}
+
+		p, err := parsePosting(l, ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsePeriodic")
+		}
+
+		pt.Postings = append(pt.Postings, p)
+	}
+
+	return pt, nil
+}
+
+// parsePeriod parses a period expression such as `monthly`,
+// `every 2 weeks`, `monthly from 2024-01-01 to 2024-12-31`, or
+// `yearly on 2024-04-15`, returning the Period and any trailing free-text
+// description.
+func parsePeriod(s string) (Period, string, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return Period{}, "", errors.Errorf("parsePeriod: empty period expression")
+	}
+
+	p := Period{Count: 1}
+	i := 0
+
+	if fields[i] == "every" {
+		i++
+		if i >= len(fields) {
+			return Period{}, "", errors.Errorf("parsePeriod: expected a count or interval after 'every'")
+		}
+
+		if n, err := strconv.Atoi(fields[i]); err == nil {
+			p.Count = n
+			i++
+		}
+	}
+
+	if i >= len(fields) {
+		return Period{}, "", errors.Errorf("parsePeriod: expected an interval (daily/weekly/monthly/yearly)")
+	}
+
+	interval, err := normalizeInterval(fields[i])
+	if err != nil {
+		return Period{}, "", errors.Wrap(err, "parsePeriod")
+	}
+	p.Interval = interval
+	i++
+
+loop:
+	for i < len(fields) {
+		switch fields[i] {
+		case "from":
+			i++
+			if i >= len(fields) {
+				return Period{}, "", errors.Errorf("parsePeriod: expected a date after 'from'")
+			}
+			t, err := time.Parse("2006-01-02", fields[i])
+			if err != nil {
+				return Period{}, "", errors.Wrap(err, "parsePeriod")
+			}
+			p.Start = t
+			i++
+		case "to":
+			i++
+			if i >= len(fields) {
+				return Period{}, "", errors.Errorf("parsePeriod: expected a date after 'to'")
+			}
+			t, err := time.Parse("2006-01-02", fields[i])
+			if err != nil {
+				return Period{}, "", errors.Wrap(err, "parsePeriod")
+			}
+			p.End = &t
+			i++
+		case "on":
+			i++
+			if i >= len(fields) {
+				return Period{}, "", errors.Errorf("parsePeriod: expected a date after 'on'")
+			}
+			t, err := time.Parse("2006-01-02", fields[i])
+			if err != nil {
+				return Period{}, "", errors.Wrap(err, "parsePeriod")
+			}
+			p.Start = t
+			p.DayOfMonth = t.Day()
+			i++
+		default:
+			break loop
+		}
+	}
+
+	description := strings.TrimSpace(strings.Join(fields[i:], " "))
+
+	return p, description, nil
+}
+
+// parseBudget parses a `budget <period-expression>` directive and the
+// indented `Account Amount` lines that follow it, e.g.:
+//
+//	budget monthly
+//		Expenses:Food	$500
+//		Expenses:Rent	$1500
+func parseBudget(rd *bufio.Reader, ctx *Context) (*Budget, error) {
+	hdr, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "parseBudget")
+	}
+	hdr = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(hdr), "budget"))
+
+	period, _, err := parsePeriod(hdr)
+	if err != nil {
+		return nil, errors.Wrap(err, "parseBudget")
+	}
+
+	b := &Budget{Period: period}
+
+	for {
+		l, err := rd.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			break
+		}
+
+		line, err := parseBudgetLine(l, ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "parseBudget")
+		}
+
+		b.Lines = append(b.Lines, line)
+	}
+
+	return b, nil
+}
+
+func parseBudgetLine(l string, ctx *Context) (BudgetLine, error) {
+	l, _ = getComment(l)
+
+	a := strings.Split(l, "\t")
+	if len(a) != 2 {
+		return BudgetLine{}, errors.Errorf("parseBudgetLine: expected 'Account\\tAmount', got %q", l)
+	}
+
+	account := ctx.ApplyAlias(strings.TrimSpace(a[0]))
+
+	amt, err := parseAmount(a[1])
+	if err != nil {
+		return BudgetLine{}, errors.Wrap(err, "parseBudgetLine")
+	}
+
+	return BudgetLine{Account: account, Amount: amt}, nil
+}
+
+func normalizeInterval(s string) (string, error) {
+	switch s {
+	case "day", "daily", "days":
+		return "daily", nil
+	case "week", "weekly", "weeks":
+		return "weekly", nil
+	case "month", "monthly", "months":
+		return "monthly", nil
+	case "year", "yearly", "years", "annually":
+		return "yearly", nil
+	default:
+		return "", errors.Errorf("normalizeInterval: unknown interval %q", s)
+	}
 }
 
 func parseTrigger(rd *bufio.Reader) (*Trigger, error) {
@@ -163,7 +545,7 @@ func parseAction(l string) (*Action, error) {
 	}
 }
 
-func parseTransaction(rd *bufio.Reader) (*Transaction, error) {
+func parseTransaction(rd *bufio.Reader, ctx *Context) (*Transaction, error) {
 	hdr, err := rd.ReadString('\n')
 	if err != nil {
 		return nil, errors.Wrap(err, "parseTransaction")
@@ -206,7 +588,7 @@ func parseTransaction(rd *bufio.Reader) (*Transaction, error) {
 			break
 		}
 
-		p, err := parsePosting(l)
+		p, err := parsePosting(l, ctx)
 		if err != nil {
 			return nil, errors.Wrap(err, "parseTransaction")
 		}
@@ -217,25 +599,150 @@ func parseTransaction(rd *bufio.Reader) (*Transaction, error) {
 	return &tr, nil
 }
 
-func parsePosting(l string) (*Posting, error) {
+func parsePosting(l string, ctx *Context) (*Posting, error) {
 	l, c := getComment(l)
 
 	a := strings.Split(l, "\t")
 
 	typ, account := parsePostingTypeAndAccount(a[0])
+	account = ctx.ApplyAlias(account)
+
+	if err := ctx.CheckAccount(account); err != nil {
+		return nil, err
+	}
+
+	tags := parseTags(c)
 
 	switch len(a) {
 	case 1:
-		return &Posting{Type: typ, Account: account, Comment: c}, nil
+		return &Posting{Type: typ, Account: account, Tags: tags, Comment: c}, nil
 	case 2:
-		n, err := decimal.NewFromString(strings.Replace(a[1], "$", "", 1))
+		amt, pt, price, err := parseAmountAndPrice(a[1])
 		if err != nil {
-			return nil, errors.Wrap(err, "parseAction")
+			return nil, errors.Wrap(err, "parsePosting")
 		}
-		return &Posting{Type: typ, Account: account, Amount: &n, Comment: c}, nil
+
+		if amt.Commodity == "" && ctx.DefaultCommodity != "" {
+			amt.Commodity = ctx.DefaultCommodity
+		}
+
+		return &Posting{Type: typ, Account: account, Amount: amt, PriceType: pt, Price: price, Tags: tags, Comment: c}, nil
 	default:
-		return nil, errors.Errorf("parseAction: wrong number of segments")
+		return nil, errors.Errorf("parsePosting: wrong number of segments")
+	}
+}
+
+// parseTags extracts `key: value` pairs from a posting comment, e.g.
+// `; reimbursable: true, vendor: Amazon` yields
+// {"reimbursable": "true", "vendor": "Amazon"}. Segments without a colon
+// are ignored, so plain free-text comments simply produce no tags.
+func parseTags(comment string) map[string]string {
+	if comment == "" {
+		return nil
 	}
+
+	tags := make(map[string]string)
+
+	for _, part := range strings.Split(comment, ",") {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		k := strings.TrimSpace(kv[0])
+		if k == "" {
+			continue
+		}
+
+		tags[k] = strings.TrimSpace(kv[1])
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	return tags
+}
+
+var amountPattern = regexp.MustCompile(`^(-)?\s*([$€£¥])?\s*(-?[0-9][0-9,]*\.?[0-9]*)\s*([A-Za-z][A-Za-z0-9_]*)?$`)
+
+// parseAmount parses a single quantity+commodity amount such as `100.00`,
+// `$100.00`, `-$100.00`, `100.00 USD`, or `€50`. The sign may appear before
+// or after the currency symbol.
+func parseAmount(s string) (*Amount, error) {
+	s = strings.TrimSpace(s)
+
+	m := amountPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, errors.Errorf("parseAmount: couldn't parse %q", s)
+	}
+
+	n, err := decimal.NewFromString(strings.Replace(m[3], ",", "", -1))
+	if err != nil {
+		return nil, errors.Wrap(err, "parseAmount")
+	}
+
+	if m[1] == "-" {
+		n = n.Neg()
+	}
+
+	commodity := m[4]
+	if commodity == "" && m[2] != "" {
+		commodity = symbolCommodity(m[2])
+	}
+
+	return &Amount{Quantity: n, Commodity: commodity}, nil
+}
+
+func symbolCommodity(sym string) string {
+	switch sym {
+	case "$":
+		return "USD"
+	case "€":
+		return "EUR"
+	case "£":
+		return "GBP"
+	case "¥":
+		return "JPY"
+	default:
+		return sym
+	}
+}
+
+// parseAmountAndPrice splits off an optional `@ PRICE` (unit price) or
+// `@@ PRICE` (total price) suffix, as in `10 AAPL @ $150.25`, before parsing
+// the base amount.
+func parseAmountAndPrice(s string) (*Amount, PriceType, *Amount, error) {
+	s = strings.TrimSpace(s)
+
+	priceType := NoPrice
+	priceStr := ""
+
+	if i := strings.Index(s, "@@"); i >= 0 {
+		priceStr = strings.TrimSpace(s[i+2:])
+		s = strings.TrimSpace(s[:i])
+		priceType = TotalPrice
+	} else if i := strings.Index(s, "@"); i >= 0 {
+		priceStr = strings.TrimSpace(s[i+1:])
+		s = strings.TrimSpace(s[:i])
+		priceType = UnitPrice
+	}
+
+	amt, err := parseAmount(s)
+	if err != nil {
+		return nil, NoPrice, nil, err
+	}
+
+	if priceType == NoPrice {
+		return amt, NoPrice, nil, nil
+	}
+
+	price, err := parseAmount(priceStr)
+	if err != nil {
+		return nil, NoPrice, nil, err
+	}
+
+	return amt, priceType, price, nil
 }
 
 func parsePostingTypeAndAccount(s string) (PostingType, string) {
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestParseAmount(t *testing.T) {
+	cases := []struct {
+		in        string
+		quantity  string
+		commodity string
+	}{
+		{"100.00", "100", ""},
+		{"$100.00", "100", "USD"},
+		{"-$5.00", "-5", "USD"},
+		{"$-5.00", "-5", "USD"},
+		{"-€50", "-50", "EUR"},
+		{"100.00 USD", "100", "USD"},
+		{"-100.00 USD", "-100", "USD"},
+		{"€50", "50", "EUR"},
+	}
+
+	for _, c := range cases {
+		amt, err := parseAmount(c.in)
+		if err != nil {
+			t.Errorf("parseAmount(%q): unexpected error: %v", c.in, err)
+			continue
+		}
+
+		if !amt.Quantity.Equal(decimal.RequireFromString(c.quantity)) {
+			t.Errorf("parseAmount(%q): got quantity %s, want %s", c.in, amt.Quantity.String(), c.quantity)
+		}
+		if amt.Commodity != c.commodity {
+			t.Errorf("parseAmount(%q): got commodity %q, want %q", c.in, amt.Commodity, c.commodity)
+		}
+	}
+}
+
+func TestParseAmountInvalid(t *testing.T) {
+	for _, in := range []string{"", "USD", "$"} {
+		if _, err := parseAmount(in); err == nil {
+			t.Errorf("parseAmount(%q): expected error, got none", in)
+		}
+	}
+}
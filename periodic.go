@@ -0,0 +1,135 @@
+package main
+
+import "time"
+
+// Period describes the recurrence of a `~` periodic transaction: every
+// Count Interval-s, starting at Start and (optionally) stopping at End. A
+// non-zero DayOfMonth records that the period was declared with `on DATE`
+// (the day of that date); occurrences are anchored by Start, and neither
+// Occurrences nor the budget evaluator reads DayOfMonth.
+type Period struct {
+	Interval   string
+	Count      int
+	Start      time.Time
+	End        *time.Time
+	DayOfMonth int
+}
+
+// Occurrences returns every date the period falls on, from Start up to and
+// including until (or Period.End, whichever is sooner).
+func (p Period) Occurrences(until time.Time) []time.Time {
+	if p.Start.IsZero() {
+		return nil
+	}
+
+	end := until
+	if p.End != nil && p.End.Before(end) {
+		end = *p.End
+	}
+
+	var out []time.Time
+
+	for i := 0; ; i++ {
+		d := p.nth(i)
+		if d.After(end) {
+			break
+		}
+		out = append(out, d)
+	}
+
+	return out
+}
+
+// nth returns the i-th occurrence (0-based) of the period. Monthly and
+// yearly intervals are always re-clamped from the original Start, rather
+// than chaining month-by-month off the previously emitted date, so that a
+// period anchored on a month-end day (e.g. "monthly from 2024-01-31")
+// tracks the true end of each month (Jan 31, Feb 29, Mar 31, Apr 30, ...)
+// instead of drifting onto whatever day the last clamp happened to land on.
+func (p Period) nth(i int) time.Time {
+	switch p.Interval {
+	case "daily":
+		return p.Start.AddDate(0, 0, i*p.Count)
+	case "weekly":
+		return p.Start.AddDate(0, 0, i*7*p.Count)
+	case "monthly":
+		return addMonthsClamped(p.Start, i*p.Count)
+	case "yearly":
+		return addMonthsClamped(p.Start, i*12*p.Count)
+	default:
+		return p.Start.AddDate(0, 0, i*p.Count)
+	}
+}
+
+// addMonthsClamped adds months to d, clamping the day-of-month to the last
+// day of the resulting month when d's day doesn't exist there, so that e.g.
+// a monthly period starting Jan 31 lands on Feb 28 (or 29 in a leap year)
+// rather than rolling over into March.
+func addMonthsClamped(d time.Time, months int) time.Time {
+	firstOfMonth := time.Date(d.Year(), d.Month(), 1, d.Hour(), d.Minute(), d.Second(), d.Nanosecond(), d.Location())
+	target := firstOfMonth.AddDate(0, months, 0)
+
+	lastDay := target.AddDate(0, 1, -1).Day()
+	day := d.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+
+	return time.Date(target.Year(), target.Month(), day, d.Hour(), d.Minute(), d.Second(), d.Nanosecond(), d.Location())
+}
+
+// PeriodicTransaction is the template declared by a `~ <period-expression>`
+// directive; ExpandPeriodic turns it into concrete Transactions.
+type PeriodicTransaction struct {
+	Period      Period
+	Description string
+	Postings    []*Posting
+}
+
+// ExpandPeriodic expands every periodic transaction template into concrete
+// Transactions from its period's Start (defaulting to defaultStart when
+// unset, e.g. for "every 2 weeks" with no explicit "from") up to until.
+// Generated postings are tagged with GeneratedBy = -1 so forecast output is
+// distinguishable from real postings, and an occurrence is skipped whenever
+// a transaction with the same date and description already exists, so
+// re-running the expander (or later recording the real transaction) doesn't
+// duplicate entries.
+func ExpandPeriodic(pts []*PeriodicTransaction, defaultStart, until time.Time, existing Transactions) Transactions {
+	seen := make(map[string]bool)
+	for _, tx := range existing {
+		seen[tx.Date.Format("2006-01-02")+"|"+tx.Description] = true
+	}
+
+	var generated Transactions
+
+	for _, pt := range pts {
+		period := pt.Period
+		if period.Start.IsZero() {
+			period.Start = defaultStart
+		}
+
+		for _, d := range period.Occurrences(until) {
+			key := d.Format("2006-01-02") + "|" + pt.Description
+			if seen[key] {
+				continue
+			}
+
+			tx := &Transaction{Date: d, Description: pt.Description}
+
+			for _, p := range pt.Postings {
+				np := *p
+				if p.Amount != nil {
+					amt := *p.Amount
+					np.Amount = &amt
+				}
+				np.GeneratedBy = -1
+
+				tx.Postings = append(tx.Postings, &np)
+			}
+
+			generated = append(generated, tx)
+		}
+	}
+
+	return generated
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriodOccurrencesMonthlyMonthEnd(t *testing.T) {
+	p := Period{Interval: "monthly", Count: 1, Start: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)}
+
+	got := p.Occurrences(time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC))
+
+	want := []string{"2024-01-31", "2024-02-29", "2024-03-31", "2024-04-30"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d occurrences, want %d: %v", len(got), len(want), got)
+	}
+
+	for i, d := range got {
+		if s := d.Format("2006-01-02"); s != want[i] {
+			t.Errorf("occurrence %d: got %s, want %s", i, s, want[i])
+		}
+	}
+}
+
+func TestPeriodOccurrencesYearly(t *testing.T) {
+	p := Period{Interval: "yearly", Count: 1, Start: time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)}
+
+	got := p.Occurrences(time.Date(2023, 2, 28, 0, 0, 0, 0, time.UTC))
+
+	want := []string{"2020-02-29", "2021-02-28", "2022-02-28", "2023-02-28"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d occurrences, want %d: %v", len(got), len(want), got)
+	}
+
+	for i, d := range got {
+		if s := d.Format("2006-01-02"); s != want[i] {
+			t.Errorf("occurrence %d: got %s, want %s", i, s, want[i])
+		}
+	}
+}
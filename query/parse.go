@@ -0,0 +1,231 @@
+package query
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var validFields = map[string]bool{
+	"acct": true,
+	"desc": true,
+	"id":   true,
+	"amt":  true,
+	"date": true,
+	"tag":  true,
+}
+
+// Parse parses a query expression into a Node tree ready for Match.
+func Parse(s string) (Node, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "Parse")
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := &parser{tokens: tokens}
+
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, errors.Wrap(err, "Parse")
+	}
+
+	if p.pos != len(p.tokens) {
+		return nil, errors.Errorf("Parse: unexpected token %q", p.tokens[p.pos])
+	}
+
+	return n, nil
+}
+
+// tokenize splits a query string into terms, "and"/"or"/"not" keywords, and
+// parentheses, keeping double-quoted values (desc:"coffee shop") intact.
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	var cur strings.Builder
+
+	inQuotes := false
+
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case c == '"':
+			cur.WriteByte(c)
+			inQuotes = !inQuotes
+		case inQuotes:
+			cur.WriteByte(c)
+		case c == '(' || c == ')':
+			flush()
+			tokens = append(tokens, string(c))
+		case c == ' ' || c == '\t':
+			flush()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+
+	if inQuotes {
+		return nil, errors.Errorf("tokenize: unterminated quoted value")
+	}
+
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+
+	return t
+}
+
+// parseOr parses a sequence of parseAnd results joined by "or".
+func (p *parser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &Or{Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses a sequence of parseNot results joined by "and", or by
+// nothing at all (adjacent terms are implicitly and-ed).
+func (p *parser) parseAnd() (Node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t == "" || t == ")" || strings.EqualFold(t, "or") {
+			break
+		}
+
+		if strings.EqualFold(t, "and") {
+			p.next()
+		}
+
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &And{Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseNot() (Node, error) {
+	if strings.EqualFold(p.peek(), "not") {
+		p.next()
+
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return &Not{Node: n}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.peek()
+
+	switch {
+	case t == "":
+		return nil, errors.Errorf("parsePrimary: unexpected end of query")
+	case t == "(":
+		p.next()
+
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ")" {
+			return nil, errors.Errorf("parsePrimary: expected ')'")
+		}
+		p.next()
+
+		return n, nil
+	default:
+		p.next()
+
+		return parseTerm(t)
+	}
+}
+
+func parseTerm(tok string) (*Term, error) {
+	i := strings.Index(tok, ":")
+	if i < 0 {
+		return nil, errors.Errorf("parseTerm: expected FIELD:VALUE, got %q", tok)
+	}
+
+	field := tok[:i]
+	if !validFields[field] {
+		return nil, errors.Errorf("parseTerm: unknown field %q", field)
+	}
+
+	value := strings.Trim(tok[i+1:], `"`)
+
+	op := OpEq
+
+	switch {
+	case strings.HasPrefix(value, ">="):
+		op, value = OpGte, value[2:]
+	case strings.HasPrefix(value, "<="):
+		op, value = OpLte, value[2:]
+	case strings.HasPrefix(value, ">"):
+		op, value = OpGt, value[1:]
+	case strings.HasPrefix(value, "<"):
+		op, value = OpLt, value[1:]
+	}
+
+	value2 := ""
+	if idx := strings.Index(value, ".."); idx >= 0 {
+		value2 = value[idx+2:]
+		value = value[:idx]
+		op = OpRange
+	}
+
+	return &Term{Field: field, Op: op, Value: value, Value2: value2}, nil
+}
@@ -0,0 +1,242 @@
+// Package query implements a small filter expression language for
+// selecting transactions and postings, inspired by the tag-based query
+// parsers found in pub/sub systems and in hledger.
+//
+// A query is a whitespace-separated sequence of FIELD:VALUE terms combined
+// with "and"/"or"/"not" and parenthesisation, e.g.:
+//
+//	acct:Expenses:Food date:2023-01..2023-06 desc:"coffee" amt:>20 tag:reimbursable not acct:Expenses:Taxes
+//
+// Adjacent terms with no explicit operator between them are implicitly
+// "and"-ed together. Supported fields are acct, desc, id, amt, date, and
+// tag; acct/desc/id values are matched as regexps, amt/date support
+// >, >=, <, <= and ".." ranges, and tag supports bare presence
+// (tag:reimbursable) or an exact value (tag:key=value).
+package query
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Transaction is the read-only view of a transaction that queries match
+// against. It's satisfied by the host application's transaction type so
+// this package doesn't need to depend on it.
+type Transaction interface {
+	QueryDate() time.Time
+	QueryDescription() string
+	QueryID() string
+}
+
+// Posting is the read-only view of a posting that queries match against.
+type Posting interface {
+	QueryAccount() string
+	QueryAmount() (decimal.Decimal, string, bool)
+	QueryTags() map[string]string
+}
+
+// Op is the comparison operator carried by a Term.
+type Op int
+
+const (
+	OpEq Op = iota
+	OpGt
+	OpGte
+	OpLt
+	OpLte
+	OpRange
+)
+
+// Node is a node in a parsed query's AST: a Term, or an And/Or/Not
+// combination of other Nodes.
+type Node interface {
+	Match(tx Transaction, p Posting) bool
+	String() string
+}
+
+// Term is a single FIELD:VALUE predicate, e.g. `acct:^Assets` or
+// `amt:>20`. Value2 is only set for OpRange terms (`date:2023-01..2023-06`).
+type Term struct {
+	Field  string
+	Op     Op
+	Value  string
+	Value2 string
+}
+
+type And struct{ Left, Right Node }
+type Or struct{ Left, Right Node }
+type Not struct{ Node Node }
+
+// Match reports whether n matches, defaulting to true for a nil Node so
+// callers can treat "no query" as "match everything".
+func Match(n Node, tx Transaction, p Posting) bool {
+	if n == nil {
+		return true
+	}
+
+	return n.Match(tx, p)
+}
+
+func (n *And) Match(tx Transaction, p Posting) bool {
+	return n.Left.Match(tx, p) && n.Right.Match(tx, p)
+}
+
+func (n *And) String() string { return n.Left.String() + " and " + n.Right.String() }
+
+func (n *Or) Match(tx Transaction, p Posting) bool {
+	return n.Left.Match(tx, p) || n.Right.Match(tx, p)
+}
+
+func (n *Or) String() string { return n.Left.String() + " or " + n.Right.String() }
+
+func (n *Not) Match(tx Transaction, p Posting) bool { return !n.Node.Match(tx, p) }
+
+func (n *Not) String() string { return "not " + n.Node.String() }
+
+func (t *Term) Match(tx Transaction, p Posting) bool {
+	switch t.Field {
+	case "acct":
+		return matchRegexp(t.Value, p.QueryAccount())
+	case "desc":
+		return matchRegexp(t.Value, tx.QueryDescription())
+	case "id":
+		return matchRegexp(t.Value, tx.QueryID())
+	case "amt":
+		q, _, ok := p.QueryAmount()
+		if !ok {
+			return false
+		}
+		return matchAmount(t.Op, t.Value, t.Value2, q)
+	case "date":
+		return matchDate(t.Op, t.Value, t.Value2, tx.QueryDate())
+	case "tag":
+		return matchTag(t.Value, p.QueryTags())
+	default:
+		return false
+	}
+}
+
+func (t *Term) String() string {
+	v := t.Value
+	if t.Op == OpRange {
+		v = t.Value + ".." + t.Value2
+	}
+
+	return t.Field + ":" + v
+}
+
+func matchRegexp(pattern, s string) bool {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return strings.Contains(s, pattern)
+	}
+
+	return re.MatchString(s)
+}
+
+func matchAmount(op Op, value, value2 string, q decimal.Decimal) bool {
+	n, err := decimal.NewFromString(value)
+	if err != nil {
+		return false
+	}
+
+	if op == OpRange {
+		n2, err := decimal.NewFromString(value2)
+		if err != nil {
+			return false
+		}
+
+		return q.GreaterThanOrEqual(n) && q.LessThanOrEqual(n2)
+	}
+
+	switch op {
+	case OpGt:
+		return q.GreaterThan(n)
+	case OpGte:
+		return q.GreaterThanOrEqual(n)
+	case OpLt:
+		return q.LessThan(n)
+	case OpLte:
+		return q.LessThanOrEqual(n)
+	default:
+		return q.Equal(n)
+	}
+}
+
+func matchDate(op Op, value, value2 string, d time.Time) bool {
+	if op == OpRange {
+		from, err1 := parseDate(value)
+		to, err2 := parseDateUpperBound(value2)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+
+		return !d.Before(from) && !d.After(to)
+	}
+
+	t, err := parseDate(value)
+	if err != nil {
+		return false
+	}
+
+	switch op {
+	case OpGt:
+		return d.After(t)
+	case OpGte:
+		return !d.Before(t)
+	case OpLt:
+		return d.Before(t)
+	case OpLte:
+		return !d.After(t)
+	default:
+		return d.Equal(t)
+	}
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("parseDate: couldn't parse %q", s)
+}
+
+// parseDateUpperBound parses s like parseDate, but expands a partial date
+// used as the upper end of a `date:from..to` range to the last day of the
+// period it names (e.g. "2023-06" -> 2023-06-30, "2023" -> 2023-12-31), so
+// that a range like `date:2023-01..2023-06` includes the whole of June
+// rather than stopping at its first instant.
+func parseDateUpperBound(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01", s); err == nil {
+		return t.AddDate(0, 1, -1), nil
+	}
+	if t, err := time.Parse("2006", s); err == nil {
+		return t.AddDate(1, 0, -1), nil
+	}
+
+	return time.Time{}, errors.Errorf("parseDate: couldn't parse %q", s)
+}
+
+func matchTag(value string, tags map[string]string) bool {
+	if len(tags) == 0 {
+		return false
+	}
+
+	if i := strings.Index(value, "="); i >= 0 {
+		v, ok := tags[value[:i]]
+		return ok && v == value[i+1:]
+	}
+
+	_, ok := tags[value]
+
+	return ok
+}
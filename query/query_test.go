@@ -0,0 +1,102 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type fakeTransaction struct {
+	date time.Time
+	desc string
+	id   string
+}
+
+func (t fakeTransaction) QueryDate() time.Time     { return t.date }
+func (t fakeTransaction) QueryDescription() string { return t.desc }
+func (t fakeTransaction) QueryID() string          { return t.id }
+
+type fakePosting struct {
+	account string
+	amount  decimal.Decimal
+	has     bool
+	tags    map[string]string
+}
+
+func (p fakePosting) QueryAccount() string { return p.account }
+func (p fakePosting) QueryAmount() (decimal.Decimal, string, bool) {
+	return p.amount, "USD", p.has
+}
+func (p fakePosting) QueryTags() map[string]string { return p.tags }
+
+func mustParse(t *testing.T, s string) Node {
+	t.Helper()
+
+	n, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", s, err)
+	}
+
+	return n
+}
+
+func TestMatchDateRangeInclusiveOfPartialUpperBound(t *testing.T) {
+	n := mustParse(t, "date:2023-01..2023-06")
+
+	tx := fakeTransaction{date: time.Date(2023, 6, 20, 0, 0, 0, 0, time.UTC)}
+	if !n.Match(tx, fakePosting{}) {
+		t.Fatal("expected 2023-06-20 to match date:2023-01..2023-06")
+	}
+
+	tx = fakeTransaction{date: time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)}
+	if n.Match(tx, fakePosting{}) {
+		t.Fatal("expected 2023-07-01 not to match date:2023-01..2023-06")
+	}
+}
+
+func TestMatchDateRangeYearUpperBound(t *testing.T) {
+	n := mustParse(t, "date:2022..2023")
+
+	tx := fakeTransaction{date: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)}
+	if !n.Match(tx, fakePosting{}) {
+		t.Fatal("expected 2023-12-31 to match date:2022..2023")
+	}
+}
+
+func TestMatchAcctAndAmount(t *testing.T) {
+	n := mustParse(t, "acct:^Expenses amt:>20")
+
+	tx := fakeTransaction{}
+	if !n.Match(tx, fakePosting{account: "Expenses:Food", amount: decimal.RequireFromString("25"), has: true}) {
+		t.Fatal("expected match")
+	}
+	if n.Match(tx, fakePosting{account: "Expenses:Food", amount: decimal.RequireFromString("10"), has: true}) {
+		t.Fatal("expected no match for amount below threshold")
+	}
+	if n.Match(tx, fakePosting{account: "Assets:Checking", amount: decimal.RequireFromString("25"), has: true}) {
+		t.Fatal("expected no match for non-Expenses account")
+	}
+}
+
+func TestMatchNot(t *testing.T) {
+	n := mustParse(t, "not acct:Expenses:Taxes")
+
+	if n.Match(fakeTransaction{}, fakePosting{account: "Expenses:Taxes"}) {
+		t.Fatal("expected no match")
+	}
+	if !n.Match(fakeTransaction{}, fakePosting{account: "Expenses:Food"}) {
+		t.Fatal("expected match")
+	}
+}
+
+func TestMatchTag(t *testing.T) {
+	n := mustParse(t, "tag:key=value")
+
+	if !n.Match(fakeTransaction{}, fakePosting{tags: map[string]string{"key": "value"}}) {
+		t.Fatal("expected match")
+	}
+	if n.Match(fakeTransaction{}, fakePosting{tags: map[string]string{"key": "other"}}) {
+		t.Fatal("expected no match")
+	}
+}